@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backend composes a storage engine with an optional transaction logger.
+// BoltStore and BadgerStore already provide their own durability, so only
+// MemoryStore needs a logger paired with it to survive a restart; Logger is
+// nil for the embedded engines.
+type Backend struct {
+	Store  Store
+	Logger TransactionLogger
+}
+
+// storeMemory, storeBolt, storeBadger and storePostgres are the values the
+// -store flag accepts.
+const (
+	storeMemory   = "memory"
+	storeBolt     = "bolt"
+	storeBadger   = "badger"
+	storePostgres = "postgres"
+)
+
+const (
+	boltFilename   = "kvs.bolt"
+	badgerDir      = "kvs-badger"
+	transactionLog = "transaction.log"
+)
+
+// LogPolicy holds the tunable thresholds that govern a transaction logger's
+// background compaction and retry behavior, so they can be set from flags
+// or environment variables reachable from main rather than only the
+// package defaults each logger is built with. A zero field is passed
+// straight through to the corresponding SetXxxPolicy call, so it means
+// whatever that method documents a zero as meaning (generally "disable this
+// trigger"), not "leave the logger's default alone" - main populates every
+// field from the same defaults the loggers themselves use, so an operator
+// who doesn't pass a flag gets the existing behavior. CompactCheckInterval is
+// the exception: the two loggers default to different intervals, so main
+// leaves it zero, which both SetCompactionPolicy methods treat as "keep the
+// current interval".
+type LogPolicy struct {
+	// CompactMaxEvents and CompactMaxBytes configure FileTransactionLogger
+	// (the memory backend); CompactMaxRows configures
+	// PostgresTransactionLogger. CompactCheckInterval applies to both.
+	CompactMaxEvents     uint64
+	CompactMaxBytes      int64
+	CompactMaxRows       uint64
+	CompactCheckInterval time.Duration
+
+	// MaxRetries and RetryBaseDelay configure PostgresTransactionLogger's
+	// retry-on-serialization-failure wrapper.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+
+	// FlushMaxEvents, FlushInterval and EventBufferSize configure
+	// PostgresTransactionLogger's batched write path.
+	FlushMaxEvents  int
+	FlushInterval   time.Duration
+	EventBufferSize int
+}
+
+// NewBackend builds the Backend named by kind. memory and postgres are
+// paired with a TransactionLogger and have its history replayed into the
+// store before it's returned; bolt and badger are durable on their own and
+// come back with a nil Logger. pg is only consulted when kind is postgres;
+// policy is only consulted when kind is memory or postgres.
+func NewBackend(kind string, pg PostgresDBParams, policy LogPolicy) (*Backend, error) {
+	switch kind {
+	case "", storeMemory:
+		return newMemoryBackend(policy)
+	case storeBolt:
+		store, err := NewBoltStore(boltFilename)
+		if err != nil {
+			return nil, err
+		}
+		return &Backend{Store: store}, nil
+	case storeBadger:
+		store, err := NewBadgerStore(badgerDir)
+		if err != nil {
+			return nil, err
+		}
+		return &Backend{Store: store}, nil
+	case storePostgres:
+		return newPostgresBackend(pg, policy)
+	default:
+		return nil, fmt.Errorf("unknown store %q (want %q, %q, %q or %q)", kind, storeMemory, storeBolt, storeBadger, storePostgres)
+	}
+}
+
+func newMemoryBackend(policy LogPolicy) (*Backend, error) {
+	store := NewMemoryStore()
+
+	logger, err := NewFileTranscationLogger(transactionLog, WithStore(store), WithCodec(BinaryEventCodec{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event logger: %w", err)
+	}
+
+	// NewFileTranscationLogger always returns a *FileTransactionLogger; the
+	// assertion just gets at the SetCompactionPolicy method the
+	// TransactionLogger interface doesn't expose.
+	if fl, ok := logger.(*FileTransactionLogger); ok {
+		fl.SetCompactionPolicy(policy.CompactMaxEvents, policy.CompactMaxBytes, policy.CompactCheckInterval)
+	}
+
+	return replayAndRun(store, logger)
+}
+
+// newPostgresBackend pairs a MemoryStore - serving reads from an in-process
+// map, same as the memory backend - with a PostgresTransactionLogger for
+// durability, since PostgresTransactionLogger logs events rather than
+// exposing them as a keyed Store itself.
+func newPostgresBackend(pg PostgresDBParams, policy LogPolicy) (*Backend, error) {
+	store := NewMemoryStore()
+
+	logger, err := NewPostgresTransactionLogger(pg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event logger: %w", err)
+	}
+
+	if pl, ok := logger.(*PostgresTransactionLogger); ok {
+		pl.SetCompactionPolicy(policy.CompactMaxRows, policy.CompactCheckInterval)
+		pl.SetRetryPolicy(policy.MaxRetries, policy.RetryBaseDelay)
+		pl.SetFlushPolicy(policy.FlushMaxEvents, policy.FlushInterval)
+		pl.SetEventBufferSize(policy.EventBufferSize)
+	}
+
+	return replayAndRun(store, logger)
+}
+
+// replayAndRun replays logger's history into store and starts logger
+// running, returning the pair as a Backend.
+func replayAndRun(store Store, logger TransactionLogger) (*Backend, error) {
+	events, errs := logger.ReadEvents()
+	e, ok := Event{}, true
+	var replayErr error
+
+	for ok && replayErr == nil {
+		select {
+		case replayErr, ok = <-errs:
+		case e, ok = <-events:
+			switch e.EventType {
+			case EventDelete:
+				replayErr = store.Delete(e.Key)
+			case EventPut:
+				replayErr = store.Put(e.Key, e.Value)
+			}
+		}
+	}
+
+	if replayErr != nil {
+		return nil, replayErr
+	}
+
+	logger.Run()
+
+	return &Backend{Store: store, Logger: logger}, nil
+}
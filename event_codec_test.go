@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEventCodecRoundTrip(t *testing.T) {
+	codecs := map[string]EventCodec{
+		"Text":   TextEventCodec{},
+		"Binary": BinaryEventCodec{},
+		"Gob":    GobEventCodec{},
+	}
+
+	// TextEventCodec's tab-separated format can't round-trip a value with
+	// whitespace in it - a documented limitation, not something these
+	// codecs disagree on - so these events stick to values that are valid
+	// input for every codec under test.
+	events := []Event{
+		{Sequence: 1, EventType: EventPut, Key: "key1", Value: "value1"},
+		{Sequence: 2, EventType: EventDelete, Key: "key2", Value: "unused"},
+		{Sequence: 3, EventType: EventPut, Key: "key3", Value: "value3"},
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			for _, e := range events {
+				if err := codec.Encode(&buf, e); err != nil {
+					t.Fatalf("Encode(%+v): %v", e, err)
+				}
+			}
+
+			for _, want := range events {
+				got, err := codec.Decode(&buf)
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				if got != want {
+					t.Fatalf("Decode() = %+v, want %+v", got, want)
+				}
+			}
+
+			if _, err := codec.Decode(&buf); err == nil {
+				t.Fatal("Decode() on exhausted stream: expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestBinaryEventCodecDecodeRejectsOversizedLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (BinaryEventCodec{}).Encode(&buf, Event{Sequence: 1, EventType: EventPut, Key: "key", Value: "value"}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// Replace the just-written key length varint (the first byte after the
+	// 9-byte header) with one claiming a length far past maxRecordFieldLen.
+	// Without a bound check, this used to reach make([]byte, length)
+	// directly and either panic or try to allocate more memory than exists.
+	encoded := buf.Bytes()
+	corrupted := append([]byte{}, encoded[:9]...)
+	corrupted = append(corrupted, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01) // varint for 1<<64 - 1
+	corrupted = append(corrupted, encoded[10:]...)
+
+	_, err := (BinaryEventCodec{}).Decode(bytes.NewReader(corrupted))
+	if !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("Decode() error = %v, want ErrCorruptRecord", err)
+	}
+}
+
+func TestTextEventCodecDecodeRejectsMalformedLine(t *testing.T) {
+	_, err := (TextEventCodec{}).Decode(strings.NewReader("not a valid record\n"))
+	if !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("Decode() error = %v, want ErrCorruptRecord", err)
+	}
+}
@@ -1,28 +1,89 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-var store = struct {
-	sync.RWMutex
-	m map[string]string
-}{m: make(map[string]string)}
-
 var ErrorNoSuchKey = errors.New("no such key")
+
+// backend holds the storage engine and (for engines that need one) the
+// transaction logger every HTTP handler goes through. It's assigned once in
+// main, based on the -store flag.
+var backend *Backend
+
+// logger is backend.Logger, kept as a package var so the rest of this file
+// reads the same as it did before Backend existed; it's nil when the
+// selected store provides its own durability.
 var logger TransactionLogger
 
+// follower, when true, puts this node in read-only mode: it rejects writes
+// and instead applies events streamed from another node's transaction log
+// via Subscribe, giving horizontal read scaling without a consensus protocol.
+var follower bool
+
 func main() {
-	if err := initializeTransactionLog(); err != nil {
+	store := flag.String("store", storeMemory, `storage engine to use: "memory", "bolt", "badger" or "postgres"`)
+	pgHost := flag.String("pg-host", envOr("KVS_PG_HOST", "localhost"), "Postgres host (store=postgres)")
+	pgDBName := flag.String("pg-dbname", envOr("KVS_PG_DBNAME", "kvs"), "Postgres database name (store=postgres)")
+	pgUser := flag.String("pg-user", envOr("KVS_PG_USER", "postgres"), "Postgres user (store=postgres)")
+	pgPassword := flag.String("pg-password", os.Getenv("KVS_PG_PASSWORD"), "Postgres password (store=postgres)")
+	compactMaxEvents := flag.Uint64("compact-max-events", envUint64Or("KVS_COMPACT_MAX_EVENTS", defaultCompactEventThreshold), "store=memory: compact the transaction log after this many buffered events (0 disables)")
+	compactMaxBytes := flag.Int64("compact-max-bytes", envInt64Or("KVS_COMPACT_MAX_BYTES", defaultCompactByteThreshold), "store=memory: compact the transaction log once it exceeds this many bytes (0 disables)")
+	pgCompactMaxRows := flag.Uint64("pg-compact-max-rows", envUint64Or("KVS_PG_COMPACT_MAX_ROWS", defaultPostgresCompactRowThreshold), "store=postgres: compact the transactions table once it holds this many rows (0 disables)")
+	compactCheckInterval := flag.Duration("compact-check-interval", envDurationOr("KVS_COMPACT_CHECK_INTERVAL", 0), "how often to check whether compaction is due (store=memory and store=postgres both use this; 0 keeps each logger's own default)")
+	pgMaxRetries := flag.Int("pg-max-retries", envIntOr("KVS_PG_MAX_RETRIES", defaultMaxRetries), "store=postgres: how many times to retry a transaction after a serialization failure or deadlock")
+	pgRetryBaseDelay := flag.Duration("pg-retry-base-delay", envDurationOr("KVS_PG_RETRY_BASE_DELAY", defaultRetryBaseDelay), "store=postgres: initial backoff between retries, doubled (with jitter) each attempt")
+	pgFlushMaxEvents := flag.Int("pg-flush-max-events", envIntOr("KVS_PG_FLUSH_MAX_EVENTS", defaultFlushMaxEvents), "store=postgres: flush a batch of writes once it reaches this many events")
+	pgFlushInterval := flag.Duration("pg-flush-interval", envDurationOr("KVS_PG_FLUSH_INTERVAL", defaultFlushInterval), "store=postgres: flush whatever writes are buffered at least this often")
+	pgEventBufferSize := flag.Int("pg-event-buffer-size", envIntOr("KVS_PG_EVENT_BUFFER_SIZE", defaultEventBufferSize), "store=postgres: capacity of the channel WritePut/WriteDelete send on")
+	flag.BoolVar(&follower, "follower", false, "run read-only, applying events replicated from another node instead of accepting writes")
+	flag.Parse()
+
+	pg := PostgresDBParams{host: *pgHost, dbName: *pgDBName, user: *pgUser, password: *pgPassword}
+	logPolicy := LogPolicy{
+		CompactMaxEvents:     *compactMaxEvents,
+		CompactMaxBytes:      *compactMaxBytes,
+		CompactMaxRows:       *pgCompactMaxRows,
+		CompactCheckInterval: *compactCheckInterval,
+		MaxRetries:           *pgMaxRetries,
+		RetryBaseDelay:       *pgRetryBaseDelay,
+		FlushMaxEvents:       *pgFlushMaxEvents,
+		FlushInterval:        *pgFlushInterval,
+		EventBufferSize:      *pgEventBufferSize,
+	}
+
+	var err error
+	backend, err = NewBackend(*store, pg, logPolicy)
+	if err != nil {
 		log.Fatal(err)
 	}
+	logger = backend.Logger
+
+	if follower {
+		// Only PostgresTransactionLogger's Subscribe genuinely follows
+		// another node's writes via LISTEN/NOTIFY. FileTransactionLogger's
+		// Subscribe only fans out events from this same process's own Run
+		// loop, so a memory-store follower would subscribe to a feed that
+		// nothing ever publishes to and silently replicate nothing.
+		if _, ok := logger.(*PostgresTransactionLogger); !ok {
+			log.Fatalf("-follower requires -store=%s, not %q", storePostgres, *store)
+		}
+		if err := runFollower(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	r := mux.NewRouter()
 
@@ -30,15 +91,93 @@ func main() {
 	r.HandleFunc("/v1/key/{key}", keyValuePutHandler).Methods("PUT")
 	r.HandleFunc("/v1/key/{key}", keyValueGetHandler).Methods("GET")
 	r.HandleFunc("/v1/key/{key}", keyValueDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/v1/snapshot", snapshotHandler).Methods("GET")
+	r.HandleFunc("/v1/restore", restoreHandler).Methods("POST")
 
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
 
+// runFollower subscribes to the transaction log's replication feed and
+// applies every streamed event to the backend store in sequence order, on
+// top of the history NewBackend already replayed.
+func runFollower() error {
+	events, err := logger.Subscribe(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to replication feed: %w", err)
+	}
+
+	go func() {
+		for e := range events {
+			switch e.EventType {
+			case EventDelete:
+				Delete(e.Key)
+			case EventPut:
+				Put(e.Key, e.Value)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// envOr returns the environment variable named key, or fallback if it's unset.
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// envUint64Or, envInt64Or, envIntOr and envDurationOr are envOr for flag
+// types flag's own Uint64/Int64/Int/Duration constructors don't have an
+// environment-variable equivalent for. An unset or unparseable value falls
+// back the same way envOr does.
+func envIntOr(key string, fallback int) int {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envUint64Or(key string, fallback uint64) uint64 {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envInt64Or(key string, fallback int64) int64 {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envDurationOr(key string, fallback time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
 func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("pong"))
 }
 
 func keyValuePutHandler(w http.ResponseWriter, r *http.Request) {
+	if follower {
+		http.Error(w, "this node is a read-only follower", http.StatusServiceUnavailable)
+		return
+	}
+
 	vars := mux.Vars(r)
 	key := vars["key"] // retrieve "key" from the request
 
@@ -60,7 +199,7 @@ func keyValuePutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.WritePut(key, string(value)) // Log a PUT event!
+	logPut(key, string(value)) // Log a PUT event, if this store uses one
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -85,6 +224,11 @@ func keyValueGetHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func keyValueDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if follower {
+		http.Error(w, "this node is a read-only follower", http.StatusServiceUnavailable)
+		return
+	}
+
 	vars := mux.Vars(r)
 	key := vars["key"]
 
@@ -93,63 +237,118 @@ func keyValueDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.WriteDelete(key) // Log a DELETE event!
+	logDelete(key) // Log a DELETE event, if this store uses one
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func Put(key string, value string) error {
-	store.Lock()
-	store.m[key] = value
-	store.Unlock()
-
-	return nil
-}
-
-func Get(key string) (string, error) {
-	store.RLock()
-	val, ok := store.m[key]
-	store.RUnlock()
+// snapshotHandler streams a point-in-time, newline-delimited JSON dump of
+// the live key/value state, for backup tools or for bootstrapping a new
+// node via /v1/restore. An optional ?snapshot_id= query parameter pins a
+// Postgres-backed logger to a previously exported snapshot. Embedded stores
+// that run without a transaction logger don't implement Snapshot, since
+// their engine-native backup tooling already covers this.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if logger == nil {
+		http.Error(w, "this store has no transaction logger to snapshot", http.StatusNotImplemented)
+		return
+	}
 
-	if !ok {
-		return "", ErrorNoSuchKey
+	ctx := r.Context()
+	if id := r.URL.Query().Get("snapshot_id"); id != "" {
+		ctx = ContextWithSnapshotID(ctx, id)
 	}
 
-	return val, nil
-}
+	rc, err := logger.Snapshot(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
 
-func Delete(key string) error {
-	store.Lock()
-	delete(store.m, key)
-	store.Unlock()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
 
-	return nil
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("snapshot stream interrupted: %v", err)
+	}
 }
 
-func initializeTransactionLog() error {
-	var err error
-
-	logger, err = NewFileTranscationLogger("transaction.log")
+// restoreHandler replays a newline-delimited JSON stream produced by
+// snapshotHandler into the store, refusing to run against a store that
+// already holds data so it can't silently clobber an existing node.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := backend.Store.Len()
 	if err != nil {
-		return fmt.Errorf("failed to create event logger: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	events, errors := logger.ReadEvents()
-	e, ok := Event{}, true
+	if n != 0 {
+		http.Error(w, "cannot restore into a non-empty store", http.StatusConflict)
+		return
+	}
 
-	for ok && err == nil {
-		select {
-		case err, ok = <-errors: // Retrieve any errors
-		case e, ok = <-events:
-			switch e.EventType {
-			case EventDelete: // Got a DELETE event!
-				err = Delete(e.Key)
-			case EventPut: // Got a PUT event!
-				err = Put(e.Key, e.Value)
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	var restored int
+
+	for decoder.More() {
+		var rec snapshotRecord
+		if err := decoder.Decode(&rec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch rec.EventType {
+		case EventPut:
+			if err := Put(rec.Key, rec.Value); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			logPut(rec.Key, rec.Value)
+		case EventDelete:
+			if err := Delete(rec.Key); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
+			logDelete(rec.Key)
+		default:
+			http.Error(w, fmt.Sprintf("unknown event type %d", rec.EventType), http.StatusBadRequest)
+			return
 		}
+
+		restored++
 	}
 
-	logger.Run()
-	return err
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "restored %d records\n", restored)
+}
+
+func Put(key string, value string) error {
+	return backend.Store.Put(key, value)
+}
+
+func Get(key string) (string, error) {
+	return backend.Store.Get(key)
+}
+
+func Delete(key string) error {
+	return backend.Store.Delete(key)
+}
+
+// logPut and logDelete record an event on backend.Logger, if the selected
+// store came with one. BoltStore and BadgerStore are durable on their own
+// and run without a logger, so these are no-ops for them.
+func logPut(key, value string) {
+	if logger != nil {
+		logger.WritePut(key, value)
+	}
+}
+
+func logDelete(key string) {
+	if logger != nil {
+		logger.WriteDelete(key)
+	}
 }
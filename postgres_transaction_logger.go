@@ -1,10 +1,67 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// defaultPostgresCompactRowThreshold is how many rows the transactions
+	// table can accumulate before a background compaction runs.
+	defaultPostgresCompactRowThreshold = 100000
+
+	// defaultPostgresCompactCheckInterval is how often Run's background
+	// ticker checks whether a compaction is due.
+	defaultPostgresCompactCheckInterval = 5 * time.Minute
+
+	// pqSerializationFailure and pqDeadlockDetected are the SQLSTATE codes
+	// PostgreSQL returns when a serializable transaction loses a write
+	// conflict - both are safe to retry since the transaction made no
+	// committed changes.
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+
+	// defaultMaxRetries is how many times a transaction is retried after a
+	// serialization failure or deadlock before giving up.
+	defaultMaxRetries = 5
+
+	// defaultRetryBaseDelay is the initial backoff between retries; it
+	// doubles (with jitter) after each attempt.
+	defaultRetryBaseDelay = 50 * time.Millisecond
+
+	// defaultFlushMaxEvents is how many events Run accumulates before
+	// flushing them as a single batch, regardless of defaultFlushInterval.
+	defaultFlushMaxEvents = 100
+
+	// defaultFlushInterval is the longest Run will hold buffered events
+	// before flushing, regardless of defaultFlushMaxEvents.
+	defaultFlushInterval = 100 * time.Millisecond
+
+	// defaultEventBufferSize is the capacity of the channel WritePut and
+	// WriteDelete send on. It's sized well above defaultFlushMaxEvents so a
+	// burst of writes can outrun one flush cycle without blocking.
+	defaultEventBufferSize = 1024
+)
 
-	_ "github.com/lib/pq"
+// postgresRetryCount and postgresRetryExhaustedCount let operators see, via
+// expvar, when Postgres writes are hitting serialization conflicts and
+// whether retries are actually resolving them.
+var (
+	postgresRetryCount          = expvar.NewInt("kvs_postgres_retry_count")
+	postgresRetryExhaustedCount = expvar.NewInt("kvs_postgres_retry_exhausted_count")
 )
 
 // PostgresTransactionLogger is a transaction logger that writes to a PostgreSQL database
@@ -12,6 +69,50 @@ type PostgresTransactionLogger struct {
 	events chan<- Event // Write-only channel for sending events
 	errors <-chan error // Read-only channel for receiving errors
 	db     *sql.DB      // The database access inteface
+
+	compactRowThreshold  uint64        // compact once the transactions table holds this many rows (0 disables)
+	compactCheckInterval time.Duration // how often Run's background ticker checks the threshold
+
+	maxRetries     int           // how many times to retry a transaction after a serialization failure or deadlock
+	retryBaseDelay time.Duration // initial backoff between retries, doubled each attempt
+
+	flushMaxEvents  int           // flush a batch once it reaches this many events
+	flushInterval   time.Duration // flush whatever is buffered at least this often
+	eventBufferSize int           // capacity of the channel WritePut/WriteDelete send on
+
+	lastSequence uint64 // client-side counter stamped onto events before they're batched, for error reporting
+
+	connStr string // connection string reused to open a dedicated pq.Listener in Subscribe
+
+	// subscribers tracks every currently active Subscribe feed's position,
+	// as a set of pointers each updated (via the sync/atomic package) by its
+	// own streamSince goroutine. Compact reads these to avoid folding away a
+	// row a lagging follower hasn't been sent yet - see minSubscriberSequence.
+	subscribersMu sync.Mutex
+	subscribers   map[*uint64]struct{}
+}
+
+// replicationChannel is the Postgres NOTIFY channel the writer signals on
+// after every committed batch, and that Subscribe listens on to learn when
+// to fetch newly written rows.
+const replicationChannel = "kvs_events"
+
+// BatchWriteError reports that a batch of events, identified by the range of
+// client-side sequence numbers Run stamped onto them before flushing,
+// failed to write. Since a batch is flushed as a single transaction, the
+// whole range failed together - none of it was committed.
+type BatchWriteError struct {
+	FirstSequence uint64
+	LastSequence  uint64
+	Err           error
+}
+
+func (e *BatchWriteError) Error() string {
+	return fmt.Sprintf("transactions %d-%d: %v", e.FirstSequence, e.LastSequence, e.Err)
+}
+
+func (e *BatchWriteError) Unwrap() error {
+	return e.Err
 }
 
 // PostgresDBParams holds the parameters for connecting to a PostgreSQL database
@@ -36,7 +137,17 @@ func NewPostgresTransactionLogger(config PostgresDBParams) (TransactionLogger, e
 		return nil, fmt.Errorf("failed to open db connection: %w", err)
 	}
 
-	logger := &PostgresTransactionLogger{db: db}
+	logger := &PostgresTransactionLogger{
+		db:                   db,
+		connStr:              connStr,
+		compactRowThreshold:  defaultPostgresCompactRowThreshold,
+		compactCheckInterval: defaultPostgresCompactCheckInterval,
+		maxRetries:           defaultMaxRetries,
+		retryBaseDelay:       defaultRetryBaseDelay,
+		flushMaxEvents:       defaultFlushMaxEvents,
+		flushInterval:        defaultFlushInterval,
+		eventBufferSize:      defaultEventBufferSize,
+	}
 
 	exists, err := logger.verfifyTableExists()
 	if err != nil {
@@ -52,6 +163,43 @@ func NewPostgresTransactionLogger(config PostgresDBParams) (TransactionLogger, e
 	return logger, nil
 }
 
+// SetCompactionPolicy overrides the thresholds that decide when Run's
+// background ticker calls Compact. A zero maxRows disables the trigger; a
+// zero checkInterval leaves the current interval in place. Call it before
+// Run.
+func (l *PostgresTransactionLogger) SetCompactionPolicy(maxRows uint64, checkInterval time.Duration) {
+	l.compactRowThreshold = maxRows
+	if checkInterval > 0 {
+		l.compactCheckInterval = checkInterval
+	}
+}
+
+// SetRetryPolicy overrides how many times a transaction is retried after a
+// serialization failure or deadlock, and the initial backoff between
+// retries. Call it before Run.
+func (l *PostgresTransactionLogger) SetRetryPolicy(maxRetries int, baseDelay time.Duration) {
+	l.maxRetries = maxRetries
+	if baseDelay > 0 {
+		l.retryBaseDelay = baseDelay
+	}
+}
+
+// SetFlushPolicy overrides how many events Run batches before flushing them
+// in a single transaction, and the longest it will hold a partial batch
+// before flushing it anyway. Call it before Run.
+func (l *PostgresTransactionLogger) SetFlushPolicy(maxEvents int, flushInterval time.Duration) {
+	l.flushMaxEvents = maxEvents
+	if flushInterval > 0 {
+		l.flushInterval = flushInterval
+	}
+}
+
+// SetEventBufferSize overrides the capacity of the channel WritePut and
+// WriteDelete send on. Call it before Run.
+func (l *PostgresTransactionLogger) SetEventBufferSize(size int) {
+	l.eventBufferSize = size
+}
+
 func (l *PostgresTransactionLogger) WritePut(key, value string) {
 	l.events <- Event{EventType: EventPut, Key: key, Value: value}
 }
@@ -104,24 +252,477 @@ func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 	return outEvent, outError
 }
 
+// Subscribe opens a dedicated pq.Listener on replicationChannel and streams
+// every transaction committed from this point on, letting a follower node
+// stay current without polling or a consensus protocol. It starts from the
+// current max sequence rather than replaying history - callers should
+// ReadEvents first to catch up, then Subscribe to keep following.
+func (l *PostgresTransactionLogger) Subscribe(ctx context.Context) (<-chan Event, error) {
+	var lastSeen uint64
+	if err := l.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(sequence), 0) FROM transactions`).Scan(&lastSeen); err != nil {
+		return nil, fmt.Errorf("cannot read starting sequence: %w", err)
+	}
+
+	// pos tracks this feed's position so Compact can see it via
+	// minSubscriberSequence and avoid folding away a row - in particular an
+	// EventDelete - before streamSince below has had a chance to deliver it.
+	pos := new(uint64)
+	atomic.StoreUint64(pos, lastSeen)
+	l.registerSubscriber(pos)
+
+	listener := pq.NewListener(l.connStr, 10*time.Second, time.Minute, nil)
+	if err := listener.Listen(replicationChannel); err != nil {
+		listener.Close()
+		l.unregisterSubscriber(pos)
+		return nil, fmt.Errorf("cannot listen on %s: %w", replicationChannel, err)
+	}
+
+	out := make(chan Event, 64)
+
+	go func() {
+		defer close(out)
+		defer listener.Close()
+		defer l.unregisterSubscriber(pos)
+
+		// Pick up anything committed between the sequence check above and
+		// Listen taking effect.
+		if err := l.streamSince(ctx, pos, out); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case _, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				// The notification payload is just a hint; re-querying by
+				// lastSeen is correct even if notifications are coalesced
+				// or a reconnect (nil notification) happened.
+				if err := l.streamSince(ctx, pos, out); err != nil {
+					return
+				}
+
+			case <-time.After(90 * time.Second):
+				_ = listener.Ping()
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// registerSubscriber and unregisterSubscriber add and remove pos from the
+// set Compact consults via minSubscriberSequence. pos is updated in place by
+// the Subscribe feed's own goroutine for as long as it's registered.
+func (l *PostgresTransactionLogger) registerSubscriber(pos *uint64) {
+	l.subscribersMu.Lock()
+	defer l.subscribersMu.Unlock()
+
+	if l.subscribers == nil {
+		l.subscribers = make(map[*uint64]struct{})
+	}
+	l.subscribers[pos] = struct{}{}
+}
+
+func (l *PostgresTransactionLogger) unregisterSubscriber(pos *uint64) {
+	l.subscribersMu.Lock()
+	defer l.subscribersMu.Unlock()
+
+	delete(l.subscribers, pos)
+}
+
+// minSubscriberSequence returns the lowest sequence number any currently
+// active Subscribe feed has consumed up to, and whether any feed is active
+// at all. Compact uses this as an upper bound on what it's allowed to fold
+// away, so a lagging follower never loses a row - in particular a delete,
+// which Compact otherwise has no other record of once the key stops being
+// live - before streamSince has sent it.
+func (l *PostgresTransactionLogger) minSubscriberSequence() (uint64, bool) {
+	l.subscribersMu.Lock()
+	defer l.subscribersMu.Unlock()
+
+	var min uint64
+	var found bool
+	for pos := range l.subscribers {
+		seq := atomic.LoadUint64(pos)
+		if !found || seq < min {
+			min, found = seq, true
+		}
+	}
+
+	return min, found
+}
+
+// streamSince fetches and forwards every transaction after *lastSeen,
+// advancing it (atomically, since Compact reads it concurrently via
+// minSubscriberSequence) as events are sent.
+func (l *PostgresTransactionLogger) streamSince(ctx context.Context, lastSeen *uint64, out chan<- Event) error {
+	rows, err := l.db.QueryContext(ctx,
+		`SELECT sequence, event_type, key, value FROM transactions WHERE sequence > $1 ORDER BY sequence`, atomic.LoadUint64(lastSeen))
+	if err != nil {
+		return fmt.Errorf("cannot query new transactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.Sequence, &e.EventType, &e.Key, &e.Value); err != nil {
+			return fmt.Errorf("cannot scan transaction: %w", err)
+		}
+
+		select {
+		case out <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		atomic.StoreUint64(lastSeen, e.Sequence)
+	}
+
+	return rows.Err()
+}
+
+// validSnapshotID matches a Postgres exported snapshot id (hex groups
+// separated by hyphens, e.g. "00000003-00000001-1"), which is all
+// SET TRANSACTION SNAPSHOT accepts. It's checked before interpolating the
+// id into SQL, since that statement can't take a bind parameter.
+var validSnapshotID = regexp.MustCompile(`^[0-9A-Fa-f-]+$`)
+
+// Snapshot opens a read-only, serializable transaction - optionally pinned
+// to a previously exported snapshot id via ContextWithSnapshotID - and
+// streams every row as newline-delimited JSON. Because the transaction
+// never writes, it doesn't block or get blocked by concurrent writers, so
+// operators get a consistent point-in-time dump without pausing traffic.
+func (l *PostgresTransactionLogger) Snapshot(ctx context.Context) (io.ReadCloser, error) {
+	tx, err := l.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin snapshot transaction: %w", err)
+	}
+
+	if id, ok := ctx.Value(snapshotIDContextKey{}).(string); ok && id != "" {
+		if !validSnapshotID.MatchString(id) {
+			tx.Rollback()
+			return nil, fmt.Errorf("invalid snapshot id %q", id)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`SET TRANSACTION SNAPSHOT '%s'`, id)); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("cannot set transaction snapshot: %w", err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT sequence, event_type, key, value FROM transactions ORDER BY sequence`)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("cannot query transactions: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer tx.Rollback() //nolint:errcheck // read-only; nothing to commit
+		defer rows.Close()
+
+		enc := json.NewEncoder(pw)
+
+		for rows.Next() {
+			var rec snapshotRecord
+			if err := rows.Scan(&rec.Sequence, &rec.EventType, &rec.Key, &rec.Value); err != nil {
+				pw.CloseWithError(fmt.Errorf("cannot scan snapshot row: %w", err))
+				return
+			}
+			if err := enc.Encode(rec); err != nil {
+				pw.CloseWithError(fmt.Errorf("cannot encode snapshot record: %w", err))
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			pw.CloseWithError(fmt.Errorf("cannot iterate snapshot rows: %w", err))
+			return
+		}
+
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
 func (l *PostgresTransactionLogger) Run() {
-	events := make(chan Event, 16) // Make an events channel
+	events := make(chan Event, l.eventBufferSize) // Make an events channel
 	l.events = events
 
 	errors := make(chan error, 1) // Make an errors channel
 	l.errors = errors
 
-	go func() {
-		query := `INSERT INTO transactions (event_type, key, value) VALUES ($1, $2, $3) RETURNING sequence`
+	go l.runBatchWriter(events, errors)
 
-		for e := range events { // Retrieve the next Event
+	if l.compactRowThreshold > 0 {
+		go l.runCompactionTicker(errors)
+	}
+}
 
-			_, err := l.db.Exec(query, e.EventType, e.Key, e.Value) // Write the event to the log
-			if err != nil {
-				errors <- err
+// runBatchWriter accumulates events off the events channel and flushes them
+// as a single transaction once a batch reaches l.flushMaxEvents events or a
+// ticker firing every l.flushInterval comes due, whichever comes first. The
+// ticker is not reset by a size-triggered flush.
+// This trades a little write latency for roughly one round-trip per batch
+// instead of one per event.
+func (l *PostgresTransactionLogger) runBatchWriter(events <-chan Event, errors chan<- error) {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, l.flushMaxEvents)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.flushBatch(context.Background(), batch); err != nil {
+			errors <- err
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				flush()
+				return
+			}
+
+			l.lastSequence++
+			e.Sequence = l.lastSequence
+			batch = append(batch, e)
+
+			if len(batch) >= l.flushMaxEvents {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flushBatch writes an entire batch as one multi-row INSERT inside a single
+// retried, serializable transaction, so the batch is committed or rejected
+// as a whole. On failure it reports the batch's client-side sequence range
+// via a *BatchWriteError rather than per-event errors, since a batch either
+// all lands or all doesn't. On success it notifies replicationChannel with
+// the highest sequence the database actually assigned, so Subscribe knows
+// there's new data to fetch.
+func (l *PostgresTransactionLogger) flushBatch(ctx context.Context, batch []Event) error {
+	first, last := batch[0].Sequence, batch[len(batch)-1].Sequence
+
+	err := l.execWithRetry(ctx, func(tx *sql.Tx) error {
+		var query strings.Builder
+		query.WriteString(`INSERT INTO transactions (event_type, key, value) VALUES `)
+
+		args := make([]any, 0, len(batch)*3)
+		for i, e := range batch {
+			if i > 0 {
+				query.WriteByte(',')
 			}
+			n := i * 3
+			fmt.Fprintf(&query, "($%d, $%d, $%d)", n+1, n+2, n+3)
+			args = append(args, e.EventType, e.Key, e.Value)
 		}
-	}()
+		query.WriteString(` RETURNING sequence`)
+
+		rows, err := tx.QueryContext(ctx, query.String(), args...)
+		if err != nil {
+			return err
+		}
+
+		var maxSequence uint64
+		for rows.Next() {
+			var seq uint64
+			if err := rows.Scan(&seq); err != nil {
+				rows.Close()
+				return err
+			}
+			if seq > maxSequence {
+				maxSequence = seq
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, replicationChannel, fmt.Sprint(maxSequence))
+		return err
+	})
+	if err != nil {
+		return &BatchWriteError{FirstSequence: first, LastSequence: last, Err: err}
+	}
+
+	return nil
+}
+
+// execWithRetry runs fn inside a serializable transaction and commits it.
+// If the transaction fails with a serialization_failure (40001) or
+// deadlock_detected (40P01) - both of which mean PostgreSQL aborted it
+// without applying any change - it retries with exponential backoff and
+// jitter, up to l.maxRetries times, incrementing the retry expvar counters
+// so operators can see conflicts happening. Any other error, or running out
+// of retries, is returned as-is.
+func (l *PostgresTransactionLogger) execWithRetry(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	delay := l.retryBaseDelay
+
+	for attempt := 0; ; attempt++ {
+		err := l.runInTx(ctx, fn)
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryableError(err) || attempt >= l.maxRetries {
+			if attempt > 0 {
+				postgresRetryExhaustedCount.Add(1)
+			}
+			return err
+		}
+
+		postgresRetryCount.Add(1)
+
+		jitter := time.Duration(rand.Int63n(int64(delay) + 1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay + jitter):
+		}
+		delay *= 2
+	}
+}
+
+func (l *PostgresTransactionLogger) runInTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := l.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback() //nolint:errcheck // original err takes precedence
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// isRetryableError reports whether err is a PostgreSQL error PostgreSQL
+// itself expects clients to retry: a serialization failure or deadlock.
+func isRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	switch pqErr.Code {
+	case pqSerializationFailure, pqDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
+// runCompactionTicker periodically checks whether the transactions table
+// has grown past the configured threshold and, if so, compacts it.
+func (l *PostgresTransactionLogger) runCompactionTicker(errors chan<- error) {
+	ticker := time.NewTicker(l.compactCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		needsCompaction, err := l.needsCompaction()
+		if err != nil {
+			errors <- fmt.Errorf("cannot check compaction threshold: %w", err)
+			continue
+		}
+		if !needsCompaction {
+			continue
+		}
+
+		if err := l.Compact(context.Background()); err != nil {
+			errors <- fmt.Errorf("background compaction failed: %w", err)
+		}
+	}
+}
+
+func (l *PostgresTransactionLogger) needsCompaction() (bool, error) {
+	var rowCount uint64
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&rowCount); err != nil {
+		return false, fmt.Errorf("failed to count transactions: %w", err)
+	}
+
+	return rowCount >= l.compactRowThreshold, nil
+}
+
+// Compact drops every row at or before its compaction floor except, for
+// each key, the single most recent one - keeping that survivor's original
+// sequence number rather than reinserting it under a new one. Reusing the
+// original sequence matters once the floor can be less than the table's
+// current max (see below): a freshly assigned, necessarily higher sequence
+// would sort after rows the floor deliberately left untouched, potentially
+// putting a folded key's now-stale compacted value ahead of a newer
+// untouched one for the same key. If a key's most recent row at or before
+// the floor is an EventDelete, every row for it up to the floor is dropped
+// and nothing survives - correct, since the key isn't live. It runs inside
+// a single serializable transaction so a crash or conflicting writer leaves
+// the table either fully compacted or entirely untouched - there's no
+// partially-rotated state to recover from, unlike the file-backed logger
+// which has to guard against a crash between its snapshot rename and its
+// log truncation.
+//
+// The floor is capped at the lowest position any active Subscribe feed has
+// reached (see minSubscriberSequence), never at the table's current max
+// sequence. Folding rows a lagging follower hasn't been sent yet would
+// delete the only record of a key's most recent EventDelete before the
+// follower ever saw it - streamSince's next sequence > lastSeen query would
+// then find no trace of the delete, so the follower would go on serving
+// that key's now-stale value forever. If every registered follower is
+// already past the would-be floor, or there are none, the cap has no
+// effect and compaction proceeds as far as it normally would.
+func (l *PostgresTransactionLogger) Compact(ctx context.Context) error {
+	return l.execWithRetry(ctx, func(tx *sql.Tx) error {
+		var floor uint64
+		if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(sequence), 0) FROM transactions`).Scan(&floor); err != nil {
+			return fmt.Errorf("cannot read max sequence: %w", err)
+		}
+
+		if minSeen, ok := l.minSubscriberSequence(); ok && minSeen < floor {
+			floor = minSeen
+		}
+		if floor == 0 {
+			return nil // a follower hasn't consumed anything yet; nothing is safe to fold
+		}
+
+		const compactQuery = `
+			WITH ranked AS (
+				SELECT sequence, event_type,
+				       ROW_NUMBER() OVER (PARTITION BY key ORDER BY sequence DESC) AS rn
+				FROM transactions
+				WHERE sequence <= $1
+			)
+			DELETE FROM transactions
+			WHERE sequence <= $1
+			AND sequence NOT IN (
+				SELECT sequence FROM ranked WHERE rn = 1 AND event_type = $2
+			)`
+
+		if _, err := tx.ExecContext(ctx, compactQuery, floor, EventPut); err != nil {
+			return fmt.Errorf("cannot drop superseded transactions: %w", err)
+		}
+
+		return nil
+	})
 }
 
 func (l *PostgresTransactionLogger) verfifyTableExists() (bool, error) {
@@ -141,7 +742,7 @@ func (l *PostgresTransactionLogger) verfifyTableExists() (bool, error) {
 func (l *PostgresTransactionLogger) createTable() error {
 	const query = `CREATE TABLE transactions (
 		sequence BIGSERIAL PRIMARY KEY,
-		type BYTE NOT NULL,
+		event_type SMALLINT NOT NULL,
 		key TEXT NOT NULL,
 		value TEXT
 	);`
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is the storage engine interface the HTTP handlers and the
+// transaction-log replay path operate through, so the wire format of the
+// transaction log can be swapped independently of where the live key/value
+// state actually lives.
+type Store interface {
+	Put(key, value string) error
+	Get(key string) (string, error)
+	Delete(key string) error
+
+	// Range calls fn, in ascending key order, for every key with the given
+	// prefix. It stops and returns nil as soon as fn returns false.
+	Range(prefix string, fn func(key, value string) bool) error
+
+	Len() (int, error)
+}
+
+// MemoryStore is the original package-global map, promoted to its own type
+// so it can implement Store alongside the embedded engines. It has no
+// durability of its own: pair it with a TransactionLogger.
+type MemoryStore struct {
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{m: make(map[string]string)}
+}
+
+func (s *MemoryStore) Put(key, value string) error {
+	s.mu.Lock()
+	s.m[key] = value
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) (string, error) {
+	s.mu.RLock()
+	value, ok := s.m[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", ErrorNoSuchKey
+	}
+
+	return value, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.m, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStore) Range(prefix string, fn func(key, value string) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !fn(k, s.m[k]) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Len() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.m), nil
+}
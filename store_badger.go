@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// BadgerStore is an embedded key/value engine backed by BadgerDB's LSM-tree
+// storage. Like BoltStore it's durable on its own, so a Backend built
+// around it doesn't need a TransactionLogger to survive a restart.
+type BadgerStore struct {
+	db *badger.DB
+}
+
+// NewBadgerStore opens (creating if necessary) a Badger database rooted at
+// dir.
+func NewBadgerStore(dir string) (*BadgerStore, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open badger store: %w", err)
+	}
+
+	return &BadgerStore{db: db}, nil
+}
+
+func (s *BadgerStore) Put(key, value string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), []byte(value))
+	})
+}
+
+func (s *BadgerStore) Get(key string) (string, error) {
+	var value []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrorNoSuchKey
+			}
+			return err
+		}
+
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+func (s *BadgerStore) Delete(key string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+func (s *BadgerStore) Range(prefix string, fn func(key, value string) bool) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefixBytes := []byte(prefix)
+
+		for it.Seek(prefixBytes); it.ValidForPrefix(prefixBytes); it.Next() {
+			item := it.Item()
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !fn(string(item.Key()), string(value)) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BadgerStore) Len() (int, error) {
+	var n int
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+func (s *BadgerStore) Close() error {
+	return s.db.Close()
+}
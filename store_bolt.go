@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the single bucket BoltStore keeps all keys in. The kvs
+// wire format doesn't need more than one bucket's worth of namespacing.
+var boltBucket = []byte("kvs")
+
+// BoltStore is an embedded, single-file key/value engine backed by
+// go.etcd.io/bbolt. Unlike MemoryStore it's durable on its own, so a
+// Backend built around it doesn't need a TransactionLogger to survive a
+// restart.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bolt store: %w", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create bolt bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Put(key, value string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), []byte(value))
+	})
+}
+
+func (s *BoltStore) Get(key string) (string, error) {
+	var value []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return ErrorNoSuchKey
+		}
+		value = append(value, v...) // v is only valid for the life of the transaction
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Range(prefix string, fn func(key, value string) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		prefixBytes := []byte(prefix)
+
+		for k, v := c.Seek(prefixBytes); k != nil && bytes.HasPrefix(k, prefixBytes); k, v = c.Next() {
+			if !fn(string(k), string(v)) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) Len() (int, error) {
+	var n int
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+
+	return n, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
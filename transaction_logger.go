@@ -1,23 +1,73 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 type TransactionLogger interface {
+	// WritePut and WriteDelete enqueue an event for Run to persist. Both
+	// send on a buffered channel and return once the event is queued, not
+	// once it's durable - call Err to learn about write failures. Under
+	// sustained load that outruns the writer, the buffer fills and these
+	// calls block until space frees up: that's intentional backpressure,
+	// not an error, and callers on the request path should expect it.
 	WritePut(key, value string)
 	WriteDelete(key string)
 	Err() <-chan error
 
 	ReadEvents() (<-chan Event, <-chan error)
 
+	// Compact discards log history that the live key/value state has
+	// superseded: FileTransactionLogger writes a snapshot of the live state
+	// and rotates the old log away, PostgresTransactionLogger deletes the
+	// superseded rows in place. Implementations must make this safe to call
+	// concurrently with Run.
+	Compact(ctx context.Context) error
+
+	// Subscribe streams events written from this moment on, so a caller
+	// that has already caught up via ReadEvents can keep following new
+	// writes - e.g. to drive a read-only replica without a consensus
+	// protocol. The returned channel is closed when ctx is canceled.
+	Subscribe(ctx context.Context) (<-chan Event, error)
+
+	// Snapshot returns a point-in-time, newline-delimited JSON stream of
+	// snapshotRecords describing the live key/value state - suitable for a
+	// backup tool to consume without blocking writers. The caller must
+	// Close the returned reader.
+	Snapshot(ctx context.Context) (io.ReadCloser, error)
+
 	Run()
 }
 
+// snapshotRecord is one line of the newline-delimited JSON stream produced
+// by Snapshot and consumed by the /v1/restore endpoint.
+type snapshotRecord struct {
+	Sequence  uint64    `json:"sequence"`
+	EventType EventType `json:"event_type"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+}
+
+// snapshotIDContextKey carries an optional Postgres exported snapshot id
+// (as produced by pg_export_snapshot) into Snapshot, so /v1/snapshot can let
+// an operator pin multiple backends to the exact same point-in-time view.
+type snapshotIDContextKey struct{}
+
+// ContextWithSnapshotID attaches a Postgres exported snapshot id to ctx for
+// PostgresTransactionLogger.Snapshot to pick up via SET TRANSACTION SNAPSHOT.
+// It has no effect on FileTransactionLogger.
+func ContextWithSnapshotID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, snapshotIDContextKey{}, id)
+}
+
 type EventType byte
 
 const (
@@ -33,20 +83,168 @@ type Event struct {
 	Value     string
 }
 
+const (
+	// defaultCompactEventThreshold is how many events FileTransactionLogger
+	// will accumulate in the tail log before a background compaction runs.
+	defaultCompactEventThreshold = 10000
+
+	// defaultCompactByteThreshold is the tail log size, in bytes, that
+	// triggers a background compaction regardless of event count.
+	defaultCompactByteThreshold = 10 << 20 // 10 MiB
+
+	// defaultCompactCheckInterval is how often the background ticker in
+	// Run checks whether a compaction is due.
+	defaultCompactCheckInterval = 30 * time.Second
+
+	snapshotFileName = "snapshot.log"
+)
+
 type FileTransactionLogger struct {
 	events       chan<- Event // Writeonly channel for sending events
 	errors       <-chan error // read-only channel for receiving errors
 	lastSequence uint64       // the last used event sequence number
 	file         *os.File     // the location of the transaction log
+
+	snapshotFilename string // the location of the compacted snapshot file
+
+	// codec encodes and decodes the tail log, l.file. It starts out as
+	// whatever the file's own codecMagic header says it is - or, for a log
+	// that predates that header, TextEventCodec, the only format such a log
+	// could be in - regardless of desiredCodec, since an already-written
+	// file can't have its existing records re-encoded in place. rotate
+	// resets it to desiredCodec once the tail log is truncated back to
+	// empty, which is what lets a node configured for BinaryEventCodec
+	// migrate a legacy text log forward the first time it compacts.
+	codec EventCodec
+
+	// desiredCodec is the codec passed via WithCodec (TextEventCodec if the
+	// option wasn't given), used to tag and encode every file this logger
+	// creates fresh: a snapshot written by Compact, and the tail log once
+	// rotate truncates it.
+	desiredCodec EventCodec
+
+	// tailHeaderLen is how many bytes of codecMagic header sit at the start
+	// of l.file, to be skipped before decoding the first record. It's 0 for
+	// a legacy log with no header.
+	tailHeaderLen int64
+
+	store Store // live key/value state that Compact and Snapshot read from
+
+	compactEventThreshold uint64        // compact after this many events since the last compaction (0 disables)
+	compactByteThreshold  int64         // compact once the tail log exceeds this many bytes (0 disables)
+	compactCheckInterval  time.Duration // how often Run's background ticker checks the thresholds
+	eventsSinceCompact    uint64        // events written to the tail log since the last compaction
+
+	// mu guards everything Run's writer goroutine and Compact/rotate touch:
+	// lastSequence, eventsSinceCompact, and the tail log file itself. Compact
+	// holds it for its entire duration, not just the rename, so a write can
+	// never land on the tail log after Compact has already read the live
+	// store and be lost when rotate truncates that same file.
+	mu sync.Mutex
+
+	subscribersMu sync.Mutex
+	subscribers   map[chan Event]struct{} // live Subscribe feeds to fan each written event out to
+}
+
+// FileTransactionLoggerOption configures optional behavior of
+// NewFileTranscationLogger.
+type FileTransactionLoggerOption func(*FileTransactionLogger)
+
+// WithCodec selects the EventCodec used to encode and decode log records.
+// The default is TextEventCodec, matching the log format this package has
+// always written; pick BinaryEventCodec or GobEventCodec for new logs that
+// need to survive arbitrary key/value bytes.
+func WithCodec(codec EventCodec) FileTransactionLoggerOption {
+	return func(l *FileTransactionLogger) {
+		l.codec = codec
+	}
 }
 
-func NewFileTranscationLogger(filename string) (TransactionLogger, error) {
+// WithStore gives the logger read access to the live key/value state so
+// Compact and Snapshot can walk it. Callers that never call either method
+// can omit this option.
+func WithStore(store Store) FileTransactionLoggerOption {
+	return func(l *FileTransactionLogger) {
+		l.store = store
+	}
+}
+
+func NewFileTranscationLogger(filename string, opts ...FileTransactionLoggerOption) (TransactionLogger, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open transaction log file: %w", err)
 	}
 
-	return &FileTransactionLogger{file: file}, nil
+	l := &FileTransactionLogger{
+		file:                  file,
+		snapshotFilename:      filepath.Join(filepath.Dir(filename), snapshotFileName),
+		codec:                 TextEventCodec{},
+		compactEventThreshold: defaultCompactEventThreshold,
+		compactByteThreshold:  defaultCompactByteThreshold,
+		compactCheckInterval:  defaultCompactCheckInterval,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.desiredCodec = l.codec
+
+	if err := l.detectOrTagTailCodec(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// detectOrTagTailCodec decides which codec reads and writes l.file. An empty
+// file (brand new, or freshly truncated by rotate) is tagged with
+// desiredCodec; a non-empty one keeps whatever its own codecMagic header
+// says, or - if it has no header at all - TextEventCodec, since that's the
+// only format any log could have been written in before this header existed.
+// Blindly decoding a pre-existing log with whatever codec the caller asked
+// for, regardless of what's actually on disk, is what silently replayed zero
+// events out of a perfectly good log in the first place.
+func (l *FileTransactionLogger) detectOrTagTailCodec() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat transaction log: %w", err)
+	}
+
+	if info.Size() == 0 {
+		if err := writeCodecHeader(l.file, l.desiredCodec); err != nil {
+			return err
+		}
+		l.codec = l.desiredCodec
+		l.tailHeaderLen = int64(codecHeaderLen)
+		return nil
+	}
+
+	codec, found, err := detectCodecHeader(l.file)
+	if err != nil {
+		return fmt.Errorf("cannot read transaction log header: %w", err)
+	}
+	if found {
+		l.codec = codec
+		l.tailHeaderLen = int64(codecHeaderLen)
+		return nil
+	}
+
+	l.codec = TextEventCodec{}
+	l.tailHeaderLen = 0
+	return nil
+}
+
+// SetCompactionPolicy overrides the thresholds that decide when Run's
+// background ticker calls Compact. A zero maxEvents or maxBytes disables
+// that trigger; a zero checkInterval leaves the current interval in place.
+// Call it before Run.
+func (l *FileTransactionLogger) SetCompactionPolicy(maxEvents uint64, maxBytes int64, checkInterval time.Duration) {
+	l.compactEventThreshold = maxEvents
+	l.compactByteThreshold = maxBytes
+	if checkInterval > 0 {
+		l.compactCheckInterval = checkInterval
+	}
 }
 
 func (l *FileTransactionLogger) WritePut(key, value string) {
@@ -70,53 +268,380 @@ func (l *FileTransactionLogger) Run() {
 
 	go func() {
 		for e := range events { // Retrieve the next Event
+			l.mu.Lock()
 
 			l.lastSequence++ // Increment sequence number
+			e.Sequence = l.lastSequence
+
+			err := l.codec.Encode(l.file, e) // Write the event to the log
+			if err == nil {
+				l.eventsSinceCompact++
+			}
+
+			l.mu.Unlock()
 
-			_, err := fmt.Fprintf(l.file, "%d\t%d\t%s\t%s\n", l.lastSequence, e.EventType, e.Key, e.Value) // Write the event to the log
 			if err != nil {
 				errors <- err
 				return
 			}
+
+			l.publish(e)
 		}
 	}()
+
+	if l.compactEventThreshold > 0 || l.compactByteThreshold > 0 {
+		go l.runCompactionTicker(errors)
+	}
 }
 
-func (l *FileTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
-	scanner := bufio.NewScanner(l.file) // Create a Scanner for l.file
-	outEvent := make(chan Event)        // An unbuffered Event channel
-	outError := make(chan error, 1)     // A buffered error channel
+// Subscribe streams every event Run writes from this point on. The file
+// backend has no separate replication log to read from, so it fans each
+// written event out to subscribers directly off the write path instead.
+// A slow subscriber doesn't block writers: events that arrive while its
+// channel is full are dropped rather than queued, since Subscribe's
+// contract is a live tail, not a durable replay (use ReadEvents for that).
+func (l *FileTransactionLogger) Subscribe(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, 64)
+
+	l.subscribersMu.Lock()
+	if l.subscribers == nil {
+		l.subscribers = make(map[chan Event]struct{})
+	}
+	l.subscribers[ch] = struct{}{}
+	l.subscribersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		l.subscribersMu.Lock()
+		delete(l.subscribers, ch)
+		l.subscribersMu.Unlock()
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (l *FileTransactionLogger) publish(e Event) {
+	l.subscribersMu.Lock()
+	defer l.subscribersMu.Unlock()
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- e:
+		default: // subscriber is behind; drop rather than block the writer
+		}
+	}
+}
+
+// Snapshot streams the live key/value state as newline-delimited JSON,
+// computed from the same in-memory state Compact snapshots to disk.
+func (l *FileTransactionLogger) Snapshot(ctx context.Context) (io.ReadCloser, error) {
+	if l.store == nil {
+		return nil, fmt.Errorf("snapshot: no store configured, pass WithStore to NewFileTranscationLogger")
+	}
+
+	live := make(map[string]string)
+	if err := l.store.Range("", func(key, value string) bool {
+		live[key] = value
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("cannot read live store: %w", err)
+	}
+
+	l.mu.Lock()
+	sequence := l.lastSequence
+	l.mu.Unlock()
+
+	pr, pw := io.Pipe()
 
 	go func() {
-		var e Event
+		enc := json.NewEncoder(pw)
+
+		for key, value := range live {
+			select {
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			default:
+			}
+
+			rec := snapshotRecord{Sequence: sequence, EventType: EventPut, Key: key, Value: value}
+			if err := enc.Encode(rec); err != nil {
+				pw.CloseWithError(fmt.Errorf("cannot encode snapshot record: %w", err))
+				return
+			}
+		}
 
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// runCompactionTicker periodically checks whether the tail log has grown
+// past the configured thresholds and, if so, compacts it.
+func (l *FileTransactionLogger) runCompactionTicker(errors chan<- error) {
+	ticker := time.NewTicker(l.compactCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !l.needsCompaction() {
+			continue
+		}
+
+		if err := l.Compact(context.Background()); err != nil {
+			errors <- fmt.Errorf("background compaction failed: %w", err)
+		}
+	}
+}
+
+func (l *FileTransactionLogger) needsCompaction() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.compactEventThreshold > 0 && l.eventsSinceCompact >= l.compactEventThreshold {
+		return true
+	}
+
+	if l.compactByteThreshold > 0 {
+		if info, err := l.file.Stat(); err == nil && info.Size() >= l.compactByteThreshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Compact snapshots the live key/value state from the configured Store to
+// snapshot.log and truncates the tail log, so future replays only have to
+// scan events written since the snapshot. The snapshot is written to a temp
+// file and fsynced before being renamed into place, and the tail log isn't
+// truncated until the new snapshot is durable, so a crash at any point
+// leaves either the old state or the new one intact, never a half-written
+// one. Compact holds l.mu for its whole duration - not just the rename and
+// truncate - so Run's writer can't append an event to the tail log between
+// the moment Compact reads the live store and the moment rotate truncates
+// that same log; without that, such an event would be captured in neither
+// the new snapshot nor the surviving tail log and would vanish on restart.
+func (l *FileTransactionLogger) Compact(ctx context.Context) error {
+	if l.store == nil {
+		return fmt.Errorf("compact: no store configured, pass WithStore to NewFileTranscationLogger")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	live := make(map[string]string)
+	if err := l.store.Range("", func(key, value string) bool {
+		live[key] = value
+		return true
+	}); err != nil {
+		return fmt.Errorf("cannot read live store: %w", err)
+	}
+
+	tmpName := l.snapshotFilename + ".tmp"
+
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot temp file: %w", err)
+	}
+
+	// The snapshot is a brand new file, so - unlike the tail log, which may
+	// already hold records in whatever codec wrote them - it's always
+	// tagged and encoded with desiredCodec. This is what lets a node
+	// migrate a legacy log forward: the snapshot captures every live key in
+	// the new codec, and rotate below retags the truncated tail log to
+	// match.
+	if err := writeCodecHeader(tmp, l.desiredCodec); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	for key, value := range live {
+		select {
+		case <-ctx.Done():
+			tmp.Close()
+			os.Remove(tmpName)
+			return ctx.Err()
+		default:
+		}
+
+		entry := Event{Sequence: l.lastSequence, EventType: EventPut, Key: key, Value: value}
+		if err := l.desiredCodec.Encode(tmp, entry); err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+			return fmt.Errorf("cannot write snapshot entry: %w", err)
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("cannot fsync snapshot temp file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("cannot close snapshot temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, l.snapshotFilename); err != nil {
+		return fmt.Errorf("cannot install snapshot file: %w", err)
+	}
+
+	return l.rotate()
+}
+
+// rotate truncates the tail log now that its contents are captured in the
+// snapshot. It must only be called once the snapshot file is durably in
+// place: if the process crashes before rotate runs, ReadEvents skips the
+// overlap between the snapshot and the untruncated tail log, since every
+// tail event in it has a sequence number no higher than the snapshot's.
+//
+// The fresh, empty tail log is tagged with desiredCodec rather than
+// whatever codec the log was in before. This is the only point a log
+// written in one codec (e.g. a legacy TextEventCodec install) moves to
+// another (e.g. BinaryEventCodec): the snapshot Compact just installed
+// already captured every live key under desiredCodec, so the old tail
+// codec has nothing left it alone carries.
+func (l *FileTransactionLogger) rotate() error {
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate transaction log: %w", err)
+	}
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek transaction log: %w", err)
+	}
+
+	if err := writeCodecHeader(l.file, l.desiredCodec); err != nil {
+		return err
+	}
+	l.codec = l.desiredCodec
+	l.tailHeaderLen = int64(codecHeaderLen)
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("cannot fsync transaction log: %w", err)
+	}
+
+	l.eventsSinceCompact = 0
+	return nil
+}
+
+func (l *FileTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
+	outEvent := make(chan Event)    // An unbuffered Event channel
+	outError := make(chan error, 1) // A buffered error channel
+
+	go func() {
 		defer close(outEvent) // Close the channels when the
 		defer close(outError) // goroutine ends
 
-		for scanner.Scan() {
-			line := scanner.Text()
+		var replayed int
 
-			if _, err := fmt.Sscanf(line, "%d\t%d\t%s\t%s", &e.Sequence, &e.EventType, &e.Key, &e.Value); err != nil && !errors.Is(err, io.EOF) {
-				outError <- fmt.Errorf("input parse error: %w", err)
+		if snapshot, err := os.Open(l.snapshotFilename); err == nil {
+			defer snapshot.Close()
+
+			codec, found, err := detectCodecHeader(snapshot)
+			if err != nil {
+				outError <- fmt.Errorf("cannot read snapshot header: %w", err)
+				return
+			}
+			if !found {
+				codec = TextEventCodec{} // predates codecMagic; the only format it could be
+			} else if _, err := snapshot.Seek(int64(codecHeaderLen), io.SeekStart); err != nil {
+				outError <- fmt.Errorf("cannot seek snapshot file: %w", err)
 				return
 			}
 
-			// Sanity check! are the sequence numbers in increasing order?
-			if l.lastSequence >= e.Sequence {
-				outError <- fmt.Errorf("transaction numbers out of sequence")
+			n, err := l.replay(snapshot, codec, outEvent, outError, true)
+			replayed += n
+			if err != nil {
 				return
 			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			outError <- fmt.Errorf("cannot open snapshot file: %w", err)
+			return
+		}
 
-			l.lastSequence = e.Sequence // Update last used sequence #
+		info, err := l.file.Stat()
+		if err != nil {
+			outError <- fmt.Errorf("cannot stat transaction log: %w", err)
+			return
+		}
+		tailSize := info.Size()
+
+		if _, err := l.file.Seek(l.tailHeaderLen, io.SeekStart); err != nil {
+			outError <- fmt.Errorf("cannot seek transaction log: %w", err)
+			return
+		}
 
-			outEvent <- e // Send the event along
+		n, err := l.replay(l.file, l.codec, outEvent, outError, false)
+		replayed += n
+		if err != nil {
+			return
 		}
 
-		if err := scanner.Err(); err != nil {
-			outError <- fmt.Errorf("transaction log read failure: %w", err)
+		// A non-empty tail log that yielded not a single replayed event
+		// means the very first record failed to decode under l.codec - the
+		// exact symptom of defaulting to the wrong codec for an existing
+		// log, which used to replay silently as "nothing to do" and empty
+		// the store out from under whoever was relying on it. Detecting
+		// the codec from the log's own header (see
+		// detectOrTagTailCodec) should make this unreachable in practice;
+		// this is a backstop for whatever that detection doesn't cover.
+		if replayed == 0 && tailSize > l.tailHeaderLen {
+			err := fmt.Errorf("transaction log has %d bytes but nothing could be replayed from it - refusing to start against a log that may be in an unrecognized or corrupt format", tailSize-l.tailHeaderLen)
+			outError <- err
 			return
 		}
 	}()
 
 	return outEvent, outError
 }
+
+// replay decodes records one at a time from a log (snapshot or tail),
+// using codec, and forwards each event to outEvent, returning how many it
+// sent. When fromSnapshot is false, events whose sequence number has
+// already been seen (left behind by a crash between installing the
+// snapshot and truncating the tail log) are skipped rather than treated as
+// corruption. A record that fails to decode because it was torn by a crash
+// mid-write (ErrCorruptRecord) ends replay of this source cleanly instead
+// of aborting it. It returns a non-nil error (after having sent it on
+// outError) only if replay must stop for some other reason.
+func (l *FileTransactionLogger) replay(r io.Reader, codec EventCodec, outEvent chan<- Event, outError chan<- error, fromSnapshot bool) (int, error) {
+	var replayed int
+
+	for {
+		e, err := codec.Decode(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, ErrCorruptRecord) {
+				return replayed, nil
+			}
+			err = fmt.Errorf("transaction log read failure: %w", err)
+			outError <- err
+			return replayed, err
+		}
+
+		if fromSnapshot {
+			// Every live key in a snapshot shares the same base sequence
+			// number, so unlike the tail log this isn't required to
+			// strictly increase - only to never go backwards.
+			if e.Sequence < l.lastSequence {
+				err := fmt.Errorf("transaction numbers out of sequence")
+				outError <- err
+				return replayed, err
+			}
+		} else if l.lastSequence >= e.Sequence {
+			continue // already applied via the snapshot (or a previous crash); not an error
+		}
+
+		if e.Sequence > l.lastSequence {
+			l.lastSequence = e.Sequence // Update last used sequence #
+		}
+
+		outEvent <- e // Send the event along
+		replayed++
+	}
+}